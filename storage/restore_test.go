@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestParseRestoreHeaderNil(t *testing.T) {
+	if status := parseRestoreHeader(nil); status != nil {
+		t.Fatalf("parseRestoreHeader(nil) = %+v, want nil", status)
+	}
+}
+
+func TestParseRestoreHeaderOngoing(t *testing.T) {
+	raw := `ongoing-request="true"`
+	status := parseRestoreHeader(&raw)
+	if status == nil || !status.Ongoing {
+		t.Fatalf("parseRestoreHeader(%q) = %+v, want Ongoing=true", raw, status)
+	}
+	if status.ExpiryDate != nil {
+		t.Fatalf("parseRestoreHeader(%q) ExpiryDate = %v, want nil", raw, status.ExpiryDate)
+	}
+}
+
+func TestParseRestoreHeaderComplete(t *testing.T) {
+	raw := `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`
+	status := parseRestoreHeader(&raw)
+	if status == nil || status.Ongoing {
+		t.Fatalf("parseRestoreHeader(%q) = %+v, want Ongoing=false", raw, status)
+	}
+	if status.ExpiryDate == nil {
+		t.Fatalf("parseRestoreHeader(%q) ExpiryDate = nil, want a parsed time", raw)
+	}
+}
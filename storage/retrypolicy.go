@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"time"
+)
+
+// Retry runs fn, retrying it according to policy until it succeeds or
+// policy says to stop. label is used only for the debug log line emitted
+// on each failed attempt. This is the shared retry loop every storage
+// backend's operations are expected to use, so a RetryPolicy set on a
+// storage (e.g. via WithRetryPolicy) behaves the same way regardless of
+// which backend (S3, S3v, FS, ...) runs it.
+func Retry(policy RetryPolicy, label string, fn func() error) error {
+	for attempt := uint(0); ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		Log.Debugf("%s failed with error: %s", label, err)
+		retry, delay := policy.ShouldRetry(err, attempt)
+		if !retry {
+			return err
+		}
+		time.Sleep(delay)
+	}
+}
+
+// RetryPolicy decides whether a failed storage operation should be retried.
+// attempt is the number of retries already made, starting at 0 for the
+// first failure. When ok is false, delay is ignored and the error is
+// returned to the caller as-is.
+type RetryPolicy interface {
+	ShouldRetry(err error, attempt uint) (ok bool, delay time.Duration)
+}
+
+// ExponentialBackoffRetryPolicy retries errors that look transient (AWS
+// request errors, throttling, timeouts) with exponential backoff and full
+// jitter: delay = rand(0, min(MaxDelay, BaseDelay*2^attempt)). It never
+// retries client errors such as NoSuchKey/AccessDenied or a cancelled
+// context, regardless of MaxRetries.
+type ExponentialBackoffRetryPolicy struct {
+	MaxRetries uint
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// NewDefaultRetryPolicy returns an ExponentialBackoffRetryPolicy seeded from
+// the retryCnt/retryInterval pair accepted by the storage constructors, so
+// existing callers keep their previous retry count while gaining backoff
+// and jitter instead of a fixed sleep.
+func NewDefaultRetryPolicy(retryCnt uint, retryInterval time.Duration) *ExponentialBackoffRetryPolicy {
+	return &ExponentialBackoffRetryPolicy{
+		MaxRetries: retryCnt,
+		BaseDelay:  retryInterval,
+		MaxDelay:   retryInterval * 30,
+	}
+}
+
+func (p *ExponentialBackoffRetryPolicy) ShouldRetry(err error, attempt uint) (bool, time.Duration) {
+	if err == nil || attempt >= p.MaxRetries || !isRetryableErr(err) {
+		return false, 0
+	}
+
+	capDelay := float64(p.MaxDelay)
+	base := float64(p.BaseDelay)
+	maxSleep := math.Min(capDelay, base*math.Exp2(float64(attempt)))
+	sleep := time.Duration(rand.Int63n(int64(maxSleep) + 1))
+	return true, sleep
+}
+
+// isRetryableErr classifies an error as transient (connection/request
+// errors, throttling, timeouts, HTTP 500/503/429) or permanent (access denied, missing
+// object, malformed request, cancellation). Unrecognized awserr.Errors are
+// treated as permanent: the allowlist of transient codes below is closed,
+// not a denylist of permanent ones. Errors that the SDK didn't wrap (e.g.
+// a raw network error) default to retryable, since those are usually
+// transient by nature.
+func isRetryableErr(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return true
+	}
+
+	switch aerr.Code() {
+	case "RequestError", "SlowDown", "Throttling", "ThrottlingException",
+		"ProvisionedThroughputExceededException", "RequestTimeout", "RequestTimeoutException":
+		return true
+	case request.CanceledErrorCode:
+		return false
+	}
+
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		switch reqErr.StatusCode() {
+		case http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusTooManyRequests:
+			return true
+		}
+	}
+
+	return false
+}
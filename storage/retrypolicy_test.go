@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestIsRetryableErr(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"context canceled", context.Canceled, false},
+		{"context deadline exceeded", context.DeadlineExceeded, false},
+		{"unwrapped error", errors.New("boom"), true},
+		{"throttling", awserr.New("Throttling", "slow down", nil), true},
+		{"slow down", awserr.New("SlowDown", "slow down", nil), true},
+		{"unknown aws error", awserr.New("InvalidAccessKeyId", "bad key", nil), false},
+		{"request failure 500", awserr.NewRequestFailure(awserr.New("InternalError", "oops", nil), http.StatusInternalServerError, "req-id"), true},
+		{"request failure 404", awserr.NewRequestFailure(awserr.New("NoSuchKey", "missing", nil), http.StatusNotFound, "req-id"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRetryableErr(c.err); got != c.want {
+				t.Fatalf("isRetryableErr(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestExponentialBackoffRetryPolicyShouldRetry(t *testing.T) {
+	policy := NewDefaultRetryPolicy(3, time.Millisecond)
+	transientErr := awserr.New("RequestError", "transient", nil)
+
+	for attempt := uint(0); attempt < 3; attempt++ {
+		retry, delay := policy.ShouldRetry(transientErr, attempt)
+		if !retry {
+			t.Fatalf("attempt %d: expected retry", attempt)
+		}
+		if delay < 0 || delay > policy.MaxDelay {
+			t.Fatalf("attempt %d: delay %s out of bounds [0, %s]", attempt, delay, policy.MaxDelay)
+		}
+	}
+
+	if retry, _ := policy.ShouldRetry(transientErr, 3); retry {
+		t.Fatal("expected no retry once MaxRetries is reached")
+	}
+
+	permanentErr := awserr.New("AccessDenied", "nope", nil)
+	if retry, _ := policy.ShouldRetry(permanentErr, 0); retry {
+		t.Fatal("expected no retry for a permanent error")
+	}
+}
+
+func TestRetrySucceedsAfterTransientFailures(t *testing.T) {
+	policy := NewDefaultRetryPolicy(3, time.Millisecond)
+	attempts := 0
+
+	err := Retry(policy, "test op", func() error {
+		attempts++
+		if attempts < 3 {
+			return awserr.New("RequestError", "transient", nil)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestRetryStopsOnPermanentError(t *testing.T) {
+	policy := NewDefaultRetryPolicy(3, time.Millisecond)
+	attempts := 0
+	permanentErr := awserr.New("AccessDenied", "nope", nil)
+
+	err := Retry(policy, "test op", func() error {
+		attempts++
+		return permanentErr
+	})
+	if err != permanentErr {
+		t.Fatalf("err = %v, want %v", err, permanentErr)
+	}
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+}
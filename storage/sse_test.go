@@ -0,0 +1,33 @@
+package storage
+
+import "testing"
+
+func TestParseSSEConfig(t *testing.T) {
+	cfg, err := ParseSSEConfig("aws:kms", "key-id", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Type != "aws:kms" || cfg.KMSKeyId != "key-id" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestParseSSEConfigCustomerKey(t *testing.T) {
+	if _, err := ParseSSEConfig("SSE-C", "", "not-base64!!"); err == nil {
+		t.Fatal("expected error for invalid base64 customer key")
+	}
+
+	cfg, err := ParseSSEConfig("SSE-C", "", "MDEyMzQ1Njc4OWFiY2RlZg==")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Type != "SSE-C" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestParseSSEConfigUnknownType(t *testing.T) {
+	if _, err := ParseSSEConfig("bogus", "", ""); err == nil {
+		t.Fatal("expected error for unknown SSE type")
+	}
+}
@@ -3,38 +3,248 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/larrabee/ratelimit"
 	"io"
 	"net/url"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 )
 
+// Default multipart upload/download tuning, matching the s3manager package
+// defaults except for download concurrency, which is raised to better
+// saturate links when syncing many large objects.
+const (
+	defaultUploadPartSize      = 5 * 1024 * 1024
+	defaultUploadConcurrency   = 5
+	defaultDownloadPartSize    = 5 * 1024 * 1024
+	defaultDownloadConcurrency = 13
+)
+
+// defaultRestorePollInterval is how often GetObjectContent re-checks an
+// in-progress Glacier/Deep Archive restore while WithRestoreTimeout is set.
+const defaultRestorePollInterval = 30 * time.Second
+
+// TransferConfig tunes the multipart upload and streaming download behavior
+// of S3vStorage. Zero values fall back to the defaults above.
+type TransferConfig struct {
+	UploadPartSize      int64
+	UploadConcurrency   int
+	DownloadPartSize    int64
+	DownloadConcurrency int
+}
+
+func (c TransferConfig) withDefaults() TransferConfig {
+	if c.UploadPartSize == 0 {
+		c.UploadPartSize = defaultUploadPartSize
+	}
+	if c.UploadConcurrency == 0 {
+		c.UploadConcurrency = defaultUploadConcurrency
+	}
+	if c.DownloadPartSize == 0 {
+		c.DownloadPartSize = defaultDownloadPartSize
+	}
+	if c.DownloadConcurrency == 0 {
+		c.DownloadConcurrency = defaultDownloadConcurrency
+	}
+	return c
+}
+
+// versionedKeyRe matches the synthesized key suffix produced by
+// encodeVersionedKey, e.g. "foo-v2006-01-02-150405-a1b2c3d4e5f6.txt". The
+// trailing hex component is versionIdHash(VersionId); S3's LastModified only
+// has second resolution, so the timestamp alone can't tell two versions of
+// the same key apart when they land in the same second.
+var versionedKeyRe = regexp.MustCompile(`^(.*)-v(\d{4}-\d{2}-\d{2}-\d{6})-([0-9a-f]{12})(\.[^.]*)?$`)
+
+const versionedKeyTimeLayout = "2006-01-02-150405"
+
+// versionIdHash returns a short, fixed-width hex digest of a VersionId for
+// embedding in a synthesized VersionedKey. It's a digest rather than the raw
+// VersionId because VersionId strings are backend-specific and can contain
+// characters that don't round-trip through a filename.
+func versionIdHash(versionId *string) string {
+	sum := sha1.Sum([]byte(aws.StringValue(versionId)))
+	return hex.EncodeToString(sum[:6])
+}
+
+// encodeVersionedKey synthesizes a flat, version-aware key for a non-latest
+// object version, following the same convention as rclone's --s3-versions:
+// the version timestamp and a hash of versionId are inserted before the file
+// extension as "-v<timestamp>-<hash>", so two versions of the same key that
+// share a LastModified second still synthesize distinct keys.
+func encodeVersionedKey(key string, versionId *string, mtime *time.Time) string {
+	if mtime == nil {
+		return key
+	}
+	ext := filepath.Ext(key)
+	base := strings.TrimSuffix(key, ext)
+	return fmt.Sprintf("%s-v%s-%s%s", base, mtime.UTC().Format(versionedKeyTimeLayout), versionIdHash(versionId), ext)
+}
+
+// DecodeVersionedKey parses a key produced by a versions-aware listing back
+// into its original key, the version's timestamp and its versionIdHash. ok
+// is false if key does not carry a synthesized version suffix. The returned
+// mtime/hash pair is not itself a VersionId; ResolveVersionedKey uses it to
+// find the matching version in the key's actual history.
+func DecodeVersionedKey(key string) (origKey string, mtime time.Time, versionHash string, ok bool) {
+	m := versionedKeyRe.FindStringSubmatch(key)
+	if m == nil {
+		return "", time.Time{}, "", false
+	}
+	t, err := time.Parse(versionedKeyTimeLayout, m[2])
+	if err != nil {
+		return "", time.Time{}, "", false
+	}
+	return m[1] + m[4], t, m[3], true
+}
+
+// SSEConfig holds the server-side encryption settings for S3vStorage.
+type SSEConfig struct {
+	Type        string
+	KMSKeyId    string
+	CustomerKey string
+}
+
+const sseTypeCustomerKey = "SSE-C"
+
+// ParseSSEConfig builds an SSEConfig from the raw string values a CLI would
+// collect as flags (e.g. --sse-type, --sse-kms-key-id, --sse-customer-key).
+// sseType must be "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms
+// or "SSE-C"; customerKey must be base64-encoded when sseType is "SSE-C".
+func ParseSSEConfig(sseType, kmsKeyId, customerKey string) (SSEConfig, error) {
+	switch sseType {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms, sseTypeCustomerKey:
+	default:
+		return SSEConfig{}, fmt.Errorf("unknown SSE type %q", sseType)
+	}
+
+	if sseType == sseTypeCustomerKey {
+		if _, err := base64.StdEncoding.DecodeString(customerKey); err != nil {
+			return SSEConfig{}, fmt.Errorf("SSE-C customer key is not valid base64: %w", err)
+		}
+	}
+
+	return SSEConfig{Type: sseType, KMSKeyId: kmsKeyId, CustomerKey: customerKey}, nil
+}
+
+// RestoreStatus reflects the x-amz-restore header reported for objects kept
+// in an archive storage class (GLACIER/DEEP_ARCHIVE).
+type RestoreStatus struct {
+	// Ongoing is true while a RestoreObject request is in flight and the
+	// object is not yet readable.
+	Ongoing bool
+	// ExpiryDate is when the restored (temporary) copy expires. Only set
+	// once the restore has completed.
+	ExpiryDate *time.Time
+}
+
+// restoreHeaderRe matches the x-amz-restore header value, e.g.
+// `ongoing-request="false", expiry-date="Fri, 23 Dec 2012 00:00:00 GMT"`.
+var restoreHeaderRe = regexp.MustCompile(`ongoing-request="(true|false)"(?:,\s*expiry-date="([^"]+)")?`)
+
+// parseRestoreHeader parses the raw x-amz-restore header value into a
+// RestoreStatus. It returns nil if raw is empty (the object is not in an
+// archive storage class, or has never been restored).
+func parseRestoreHeader(raw *string) *RestoreStatus {
+	if raw == nil || *raw == "" {
+		return nil
+	}
+	m := restoreHeaderRe.FindStringSubmatch(*raw)
+	if m == nil {
+		return nil
+	}
+
+	status := &RestoreStatus{Ongoing: m[1] == "true"}
+	if m[2] != "" {
+		if expiry, err := time.Parse(time.RFC1123, m[2]); err == nil {
+			status.ExpiryDate = &expiry
+		}
+	}
+	return status
+}
+
+// isArchiveStorageClass reports whether storageClass requires a restore
+// before the object content can be read.
+func isArchiveStorageClass(storageClass *string) bool {
+	switch aws.StringValue(storageClass) {
+	case s3.StorageClassGlacier, s3.StorageClassDeepArchive:
+		return true
+	default:
+		return false
+	}
+}
+
+// encodeTagging serializes tags into the URL-encoded "k=v&..." form expected
+// by the Tagging field of PutObjectInput/UploadInput. Returns nil if tags is
+// empty, so PutObject sends no Tagging and the uploaded object has no tags.
+func encodeTagging(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return aws.String(values.Encode())
+}
+
+// decodeTagSet converts a GetObjectTagging response's TagSet into the plain
+// map[string]string carried on Object.Tags.
+func decodeTagSet(tagSet []*s3.Tag) map[string]string {
+	if len(tagSet) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(tagSet))
+	for _, t := range tagSet {
+		tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return tags
+}
+
 // S3vStorage configuration.
 type S3vStorage struct {
-	awsSvc        *s3.S3
-	awsSession    *session.Session
-	awsBucket     *string
-	prefix        string
-	keysPerReq    int64
-	retryCnt      uint
-	retryInterval time.Duration
-	ctx           context.Context
-	listMarker    *string
-	rlBucket      ratelimit.Bucket
+	awsSvc            *s3.S3
+	awsSession        *session.Session
+	awsBucket         *string
+	prefix            string
+	keysPerReq        int64
+	retryCnt          uint
+	retryInterval     time.Duration
+	ctx               context.Context
+	listMarker        *string
+	rlBucket          ratelimit.Bucket
+	sse               SSEConfig
+	sseCustomerKeyRaw []byte
+	sseCustomerKeyMD5 string
+	flatVersions      bool
+	retryPolicy       RetryPolicy
+	transfer          TransferConfig
+	uploader          *s3manager.Uploader
+	downloader        *s3manager.Downloader
+	restorePoll       time.Duration
+	restoreTimeout    time.Duration
+	fetchTags         bool
 }
 
 // NewS3vStorage return new configured S3 storage.
 // You should always create new storage with this constructor.
 //
 // It differs from S3 storage in that it can work with file versions.
-func NewS3vStorage(awsAccessKey, awsSecretKey, awsRegion, endpoint, bucketName, prefix string, keysPerReq int64, retryCnt uint, retryInterval time.Duration) *S3vStorage {
+func NewS3vStorage(awsAccessKey, awsSecretKey, awsRegion, endpoint, bucketName, prefix string, keysPerReq int64, retryCnt uint, retryInterval time.Duration, sse SSEConfig, transfer TransferConfig) *S3vStorage {
 	sess := session.Must(session.NewSession())
 	sess.Config.S3ForcePathStyle = aws.Bool(true)
 	sess.Config.CredentialsChainVerboseErrors = aws.Bool(true)
@@ -69,8 +279,31 @@ func NewS3vStorage(awsAccessKey, awsSecretKey, awsRegion, endpoint, bucketName,
 		retryInterval: retryInterval,
 		ctx:           context.TODO(),
 		rlBucket:      ratelimit.NewFakeBucket(),
+		sse:           sse,
+		retryPolicy:   NewDefaultRetryPolicy(retryCnt, retryInterval),
+		transfer:      transfer.withDefaults(),
+		restorePoll:   defaultRestorePollInterval,
 	}
 
+	if sse.Type == sseTypeCustomerKey && sse.CustomerKey != "" {
+		rawKey, err := base64.StdEncoding.DecodeString(sse.CustomerKey)
+		if err != nil {
+			Log.Fatalf("SSE-C customer key is not valid base64: %s", err)
+		}
+		sum := md5.Sum(rawKey)
+		storage.sseCustomerKeyRaw = rawKey
+		storage.sseCustomerKeyMD5 = base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	storage.uploader = s3manager.NewUploaderWithClient(storage.awsSvc, func(u *s3manager.Uploader) {
+		u.PartSize = storage.transfer.UploadPartSize
+		u.Concurrency = storage.transfer.UploadConcurrency
+	})
+	storage.downloader = s3manager.NewDownloaderWithClient(storage.awsSvc, func(d *s3manager.Downloader) {
+		d.PartSize = storage.transfer.DownloadPartSize
+		d.Concurrency = storage.transfer.DownloadConcurrency
+	})
+
 	return &storage
 }
 
@@ -79,6 +312,12 @@ func (storage *S3vStorage) WithContext(ctx context.Context) {
 	storage.ctx = ctx
 }
 
+// WithRetryPolicy overrides the storage's default retry policy (exponential
+// backoff with full jitter, seeded from retryCnt/retryInterval).
+func (storage *S3vStorage) WithRetryPolicy(policy RetryPolicy) {
+	storage.retryPolicy = policy
+}
+
 // WithRateLimit set rate limit (bytes/sec) for storage.
 func (storage *S3vStorage) WithRateLimit(limit int) error {
 	bucket, err := ratelimit.NewBucketWithRate(float64(limit), int64(limit))
@@ -89,18 +328,53 @@ func (storage *S3vStorage) WithRateLimit(limit int) error {
 	return nil
 }
 
+// WithRestoreTimeout enables transparent Glacier/Deep Archive restore
+// waiting in GetObjectContent: once a restore has been requested (see
+// RestoreObject), GetObjectContent polls HEAD every poll interval until
+// the restore completes or timeout elapses. A zero timeout disables
+// waiting, which is the default.
+func (storage *S3vStorage) WithRestoreTimeout(timeout, poll time.Duration) {
+	storage.restoreTimeout = timeout
+	if poll > 0 {
+		storage.restorePoll = poll
+	}
+}
+
+// WithObjectTagging makes GetObjectMeta/GetObjectContent issue an extra
+// GetObjectTagging request to populate obj.Tags. It is opt-in since it
+// doubles the number of requests made per object.
+func (storage *S3vStorage) WithObjectTagging(enabled bool) {
+	storage.fetchTags = enabled
+}
+
+// WithVersionedKeys switches List into a flat, version-aware listing mode
+// (the equivalent of rclone's --s3-versions): non-latest versions get a
+// synthesized VersionedKey (see encodeVersionedKey) for use as a flat
+// display/target name, so every version of every key can be synced as if
+// it were its own object. Object.Key/VersionId always stay the real
+// (key, versionId) pair S3 expects, so the returned objects remain usable
+// with GetObjectContent/GetObjectMeta/DeleteObject/RestoreObject as-is.
+func (storage *S3vStorage) WithVersionedKeys(enabled bool) {
+	storage.flatVersions = enabled
+}
+
 // List S3 bucket and send founded objects versions to chan.
 func (storage *S3vStorage) List(output chan<- *Object) error {
 	listObjectsFn := func(p *s3.ListObjectVersionsOutput, lastPage bool) bool {
 		for _, o := range p.Versions {
 			key, _ := url.QueryUnescape(aws.StringValue(o.Key))
-			output <- &Object{Key: &key, VersionId: o.VersionId, ETag: strongEtag(o.ETag), Mtime: o.LastModified, IsLatest: o.IsLatest}
+			obj := &Object{Key: &key, VersionId: o.VersionId, ETag: strongEtag(o.ETag), Mtime: o.LastModified, IsLatest: o.IsLatest, StorageClass: o.StorageClass, ContentLength: o.Size}
+			if storage.flatVersions && !aws.BoolValue(o.IsLatest) {
+				versionedKey := encodeVersionedKey(key, o.VersionId, o.LastModified)
+				obj.VersionedKey = &versionedKey
+			}
+			output <- obj
 		}
 		storage.listMarker = p.VersionIdMarker
 		return !lastPage // continue paging
 	}
 
-	for i := uint(0); ; i++ {
+	err := Retry(storage.retryPolicy, "S3 listing", func() error {
 		input := &s3.ListObjectVersionsInput{
 			Bucket:          storage.awsBucket,
 			Prefix:          aws.String(storage.prefix),
@@ -108,28 +382,80 @@ func (storage *S3vStorage) List(output chan<- *Object) error {
 			EncodingType:    aws.String(s3.EncodingTypeUrl),
 			VersionIdMarker: storage.listMarker,
 		}
-		err := storage.awsSvc.ListObjectVersionsPagesWithContext(storage.ctx, input, listObjectsFn)
-		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 listing failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
-			continue
-		} else if (err != nil) && (i == storage.retryCnt) {
-			Log.Debugf("S3 listing failed with error: %s", err)
-			return err
-		} else {
-			Log.Debugf("Listing bucket finished")
-			return err
+		return storage.awsSvc.ListObjectVersionsPagesWithContext(storage.ctx, input, listObjectsFn)
+	})
+	if err == nil {
+		Log.Debugf("Listing bucket finished")
+	}
+	return err
+}
+
+// ResolveVersionedKey translates a VersionedKey produced by a
+// WithVersionedKeys(true) listing (or written out flat by a target backend)
+// back into an Object carrying the real (key, versionId) pair, by decoding
+// the synthesized timestamp and matching it against the key's actual
+// version history. This is what lets a target side read back, restore or
+// delete the specific version a versioned key stands for.
+func (storage *S3vStorage) ResolveVersionedKey(versionedKey string) (*Object, error) {
+	origKey, mtime, versionHash, ok := DecodeVersionedKey(versionedKey)
+	if !ok {
+		return nil, fmt.Errorf("%q does not carry a synthesized version suffix", versionedKey)
+	}
+
+	var matches []*s3.ObjectVersion
+	listObjectsFn := func(p *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, o := range p.Versions {
+			key, _ := url.QueryUnescape(aws.StringValue(o.Key))
+			if key == origKey && o.LastModified != nil && o.LastModified.Equal(mtime) && versionIdHash(o.VersionId) == versionHash {
+				matches = append(matches, o)
+			}
 		}
+		return !lastPage
 	}
+
+	err := Retry(storage.retryPolicy, fmt.Sprintf("S3 version lookup for %q", origKey), func() error {
+		matches = nil
+		input := &s3.ListObjectVersionsInput{
+			Bucket:       storage.awsBucket,
+			Prefix:       aws.String(origKey),
+			EncodingType: aws.String(s3.EncodingTypeUrl),
+		}
+		return storage.awsSvc.ListObjectVersionsPagesWithContext(storage.ctx, input, listObjectsFn)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no version of %q matches the timestamp/version hash encoded in %q", origKey, versionedKey)
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("ambiguous version for %q: %d versions match the timestamp/version hash encoded in %q", origKey, len(matches), versionedKey)
+	}
+	match := matches[0]
+
+	return &Object{
+		Key:           &origKey,
+		VersionId:     match.VersionId,
+		ETag:          strongEtag(match.ETag),
+		Mtime:         match.LastModified,
+		IsLatest:      match.IsLatest,
+		StorageClass:  match.StorageClass,
+		ContentLength: match.Size,
+	}, nil
 }
 
 // PutObject saves object to S3.
 // PutObject ignore VersionId, it always save object as latest version.
+//
+// Upload goes through an s3manager.Uploader so large objects are sent as a
+// multipart upload rather than a single PutObject body. The content is read
+// from obj.Content, which holds the whole object in memory.
 func (storage *S3vStorage) PutObject(obj *Object) error {
 	objReader := bytes.NewReader(*obj.Content)
 	rlReader := ratelimit.NewReadSeeker(objReader, storage.rlBucket)
 
-	input := &s3.PutObjectInput{
+	input := &s3manager.UploadInput{
 		Bucket:             storage.awsBucket,
 		Key:                aws.String(filepath.Join(storage.prefix, *obj.Key)),
 		Body:               rlReader,
@@ -140,63 +466,140 @@ func (storage *S3vStorage) PutObject(obj *Object) error {
 		ACL:                obj.ACL,
 		Metadata:           obj.Metadata,
 		CacheControl:       obj.CacheControl,
+		StorageClass:       obj.StorageClass,
+		Tagging:            encodeTagging(obj.Tags),
 	}
+	storage.applySSEToUploadInput(input)
 
-	for i := uint(0); ; i++ {
-		_, err := storage.awsSvc.PutObjectWithContext(storage.ctx, input)
-		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj uploading failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
-			continue
-		} else if (err != nil) && (i == storage.retryCnt) {
+	return Retry(storage.retryPolicy, "S3 obj uploading", func() error {
+		if _, err := objReader.Seek(0, io.SeekStart); err != nil {
 			return err
 		}
+		_, err := storage.uploader.UploadWithContext(storage.ctx, input)
+		return err
+	})
+}
+
+// GetObjectContent read object content and metadata from S3.
+//
+// Most objects this tool syncs are well below a single download part, and
+// for those a plain GetObject already returns the content and every header
+// Object needs in one request. The s3manager.Downloader path (and the
+// GetObjectMeta HEAD request it needs first, since Downloader itself only
+// reports bytes written) is reserved for objects already known to be larger
+// than a download part, or an archive-class object that must be checked for
+// an in-progress restore. obj.ContentLength is normally already known from a
+// prior List() call; it's nil only when a caller hands GetObjectContent a
+// freshly constructed Object, in which case this conservatively takes the
+// HEAD-then-download path since the size isn't known yet.
+func (storage *S3vStorage) GetObjectContent(obj *Object) error {
+	if !storage.needsManagedDownload(obj) {
+		return storage.getObjectContentDirect(obj)
+	}
+
+	if err := storage.GetObjectMeta(obj); err != nil {
+		return err
+	}
+
+	if storage.restoreTimeout > 0 && isArchiveStorageClass(obj.StorageClass) {
+		if err := storage.waitForRestore(obj); err != nil {
+			return err
+		}
+	}
 
+	input := &s3.GetObjectInput{
+		Bucket:    storage.awsBucket,
+		Key:       obj.Key,
+		VersionId: obj.VersionId,
+	}
+	storage.applySSEToGetInput(input)
+
+	return Retry(storage.retryPolicy, "S3 obj content downloading", func() error {
+		buf := aws.NewWriteAtBuffer(make([]byte, 0))
+		rlWriter := &rateLimitedWriterAt{w: buf, bucket: storage.rlBucket}
+		_, err := storage.downloader.DownloadWithContext(storage.ctx, rlWriter, input)
+		if err != nil {
+			return err
+		}
+		data := buf.Bytes()
+		obj.Content = &data
 		return nil
+	})
+}
+
+// needsManagedDownload reports whether obj should go through the
+// GetObjectMeta+s3manager.Downloader path rather than a single direct
+// GetObject. True whenever obj's size isn't known yet, is large enough to
+// benefit from concurrent byte-range parts, or is archived while a restore
+// timeout is set (WithRestoreTimeout) and so needs the GetObjectMeta HEAD
+// that the restore wait relies on.
+func (storage *S3vStorage) needsManagedDownload(obj *Object) bool {
+	if storage.restoreTimeout > 0 && isArchiveStorageClass(obj.StorageClass) {
+		return true
 	}
+	return obj.ContentLength == nil || aws.Int64Value(obj.ContentLength) > storage.transfer.DownloadPartSize
 }
 
-// GetObjectContent read object content and metadata from S3.
-func (storage *S3vStorage) GetObjectContent(obj *Object) error {
+// getObjectContentDirect fetches obj's content with a single GetObject call,
+// populating the same metadata fields GetObjectMeta would from the same
+// response, for objects too small to benefit from a managed, multi-part
+// download.
+func (storage *S3vStorage) getObjectContentDirect(obj *Object) error {
 	input := &s3.GetObjectInput{
 		Bucket:    storage.awsBucket,
 		Key:       obj.Key,
 		VersionId: obj.VersionId,
 	}
+	storage.applySSEToGetInput(input)
 
-	for i := uint(0); ; i++ {
+	return Retry(storage.retryPolicy, "S3 obj content downloading", func() error {
 		result, err := storage.awsSvc.GetObjectWithContext(storage.ctx, input)
-		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj content downloading request failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
-			continue
-		} else if (err != nil) && (i == storage.retryCnt) {
+		if err != nil {
 			return err
 		}
+		defer result.Body.Close()
 
 		buf := bytes.NewBuffer(make([]byte, 0, aws.Int64Value(result.ContentLength)))
-		_, err = io.Copy(ratelimit.NewWriter(buf, storage.rlBucket), result.Body)
-		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj content downloading failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
-			continue
-		} else if (err != nil) && (i == storage.retryCnt) {
+		if _, err := io.Copy(ratelimit.NewWriter(buf, storage.rlBucket), result.Body); err != nil {
 			return err
 		}
 
 		data := buf.Bytes()
 		obj.Content = &data
+		obj.VersionId = result.VersionId
 		obj.ContentType = result.ContentType
 		obj.ContentDisposition = result.ContentDisposition
 		obj.ContentEncoding = result.ContentEncoding
 		obj.ContentLanguage = result.ContentLanguage
+		obj.ContentLength = result.ContentLength
 		obj.ETag = strongEtag(result.ETag)
 		obj.Metadata = result.Metadata
 		obj.Mtime = result.LastModified
 		obj.CacheControl = result.CacheControl
+		obj.StorageClass = result.StorageClass
+		obj.Restore = parseRestoreHeader(result.Restore)
+
+		if storage.fetchTags {
+			if err := storage.fetchObjectTagging(obj); err != nil {
+				return err
+			}
+		}
 
 		return nil
-	}
+	})
+}
+
+// rateLimitedWriterAt throttles writes made by s3manager.Downloader through
+// a ratelimit.Bucket, mirroring the ratelimit.NewWriter wrapper used for the
+// non-parallel read/write paths.
+type rateLimitedWriterAt struct {
+	w      io.WriterAt
+	bucket ratelimit.Bucket
+}
+
+func (r *rateLimitedWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	r.bucket.Wait(int64(len(p)))
+	return r.w.WriteAt(p, off)
 }
 
 // GetObjectMeta update object metadata from S3.
@@ -206,28 +609,55 @@ func (storage *S3vStorage) GetObjectMeta(obj *Object) error {
 		Key:       obj.Key,
 		VersionId: obj.VersionId,
 	}
+	storage.applySSEToHeadInput(input)
 
-	for i := uint(0); ; i++ {
+	return Retry(storage.retryPolicy, "S3 obj meta downloading", func() error {
 		result, err := storage.awsSvc.HeadObjectWithContext(storage.ctx, input)
-		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj meta downloading request failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
-			continue
-		} else if (err != nil) && (i == storage.retryCnt) {
+		if err != nil {
 			return err
 		}
 
+		obj.VersionId = result.VersionId
 		obj.ContentType = result.ContentType
 		obj.ContentDisposition = result.ContentDisposition
 		obj.ContentEncoding = result.ContentEncoding
 		obj.ContentLanguage = result.ContentLanguage
+		obj.ContentLength = result.ContentLength
 		obj.ETag = strongEtag(result.ETag)
 		obj.Metadata = result.Metadata
 		obj.Mtime = result.LastModified
 		obj.CacheControl = result.CacheControl
+		obj.StorageClass = result.StorageClass
+		obj.Restore = parseRestoreHeader(result.Restore)
+
+		if storage.fetchTags {
+			if err := storage.fetchObjectTagging(obj); err != nil {
+				return err
+			}
+		}
 
 		return nil
+	})
+}
+
+// fetchObjectTagging populates obj.Tags via a GetObjectTagging request.
+// It is only called when WithObjectTagging has been enabled, since it costs
+// an extra request per object.
+func (storage *S3vStorage) fetchObjectTagging(obj *Object) error {
+	input := &s3.GetObjectTaggingInput{
+		Bucket:    storage.awsBucket,
+		Key:       obj.Key,
+		VersionId: obj.VersionId,
 	}
+
+	return Retry(storage.retryPolicy, "S3 obj tagging downloading", func() error {
+		result, err := storage.awsSvc.GetObjectTaggingWithContext(storage.ctx, input)
+		if err != nil {
+			return err
+		}
+		obj.Tags = decodeTagSet(result.TagSet)
+		return nil
+	})
 }
 
 // DeleteObject remove object from S3.
@@ -238,17 +668,66 @@ func (storage *S3vStorage) DeleteObject(obj *Object) error {
 		VersionId: obj.VersionId,
 	}
 
-	for i := uint(0); ; i++ {
+	return Retry(storage.retryPolicy, "S3 obj removing", func() error {
 		_, err := storage.awsSvc.DeleteObjectWithContext(storage.ctx, input)
-		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj removing failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
-			continue
-		} else if (err != nil) && (i == storage.retryCnt) {
-			return err
+		return err
+	})
+}
+
+// RestoreObject requests a temporary restored copy of an archived
+// (GLACIER/DEEP_ARCHIVE) object. tier is one of s3.TierBulk,
+// s3.TierStandard or s3.TierExpedited; days is how long the restored copy
+// stays readable. Combined with WithRestoreTimeout, a subsequent
+// GetObjectContent call will wait for the restore to complete before
+// downloading.
+func (storage *S3vStorage) RestoreObject(obj *Object, tier string, days int64) error {
+	input := &s3.RestoreObjectInput{
+		Bucket:    storage.awsBucket,
+		Key:       obj.Key,
+		VersionId: obj.VersionId,
+		RestoreRequest: &s3.RestoreRequest{
+			Days: aws.Int64(days),
+			GlacierJobParameters: &s3.GlacierJobParameters{
+				Tier: aws.String(tier),
+			},
+		},
+	}
+
+	return Retry(storage.retryPolicy, "S3 obj restoring", func() error {
+		_, err := storage.awsSvc.RestoreObjectWithContext(storage.ctx, input)
+		return err
+	})
+}
+
+// waitForRestore polls GetObjectMeta until obj's restore completes
+// (x-amz-restore reports ongoing-request="false") or storage.restoreTimeout
+// elapses. obj.Restore == nil means no restore was ever requested for this
+// object, which RestoreObject would have rejected with ObjectAlreadyInActiveTierError
+// or left pending forever, so that case fails fast instead of polling out
+// the full timeout.
+func (storage *S3vStorage) waitForRestore(obj *Object) error {
+	if obj.Restore == nil {
+		return fmt.Errorf("object %q has no restore in progress; call RestoreObject first", aws.StringValue(obj.Key))
+	}
+
+	deadline := time.Now().Add(storage.restoreTimeout)
+	for {
+		if !obj.Restore.Ongoing {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for restore of %q after %s", aws.StringValue(obj.Key), storage.restoreTimeout)
 		}
 
-		return nil
+		Log.Debugf("Waiting for restore of %q to complete", aws.StringValue(obj.Key))
+		time.Sleep(storage.restorePoll)
+
+		if err := storage.GetObjectMeta(obj); err != nil {
+			return err
+		}
+		if obj.Restore == nil {
+			return fmt.Errorf("object %q no longer reports a restore in progress", aws.StringValue(obj.Key))
+		}
 	}
 }
 
@@ -256,3 +735,45 @@ func (storage *S3vStorage) DeleteObject(obj *Object) error {
 func (storage *S3vStorage) GetStorageType() Type {
 	return TypeS3Versioned
 }
+
+// applySSEToUploadInput set server-side encryption fields on an
+// s3manager.UploadInput according to the storage's SSE configuration.
+func (storage *S3vStorage) applySSEToUploadInput(input *s3manager.UploadInput) {
+	switch storage.sse.Type {
+	case "":
+		return
+	case sseTypeCustomerKey:
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(string(storage.sseCustomerKeyRaw))
+		input.SSECustomerKeyMD5 = aws.String(storage.sseCustomerKeyMD5)
+	case s3.ServerSideEncryptionAwsKms:
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		if storage.sse.KMSKeyId != "" {
+			input.SSEKMSKeyId = aws.String(storage.sse.KMSKeyId)
+		}
+	default:
+		input.ServerSideEncryption = aws.String(storage.sse.Type)
+	}
+}
+
+// applySSEToGetInput set SSE-C fields on a GetObjectInput so that objects
+// encrypted with a customer supplied key remain readable.
+func (storage *S3vStorage) applySSEToGetInput(input *s3.GetObjectInput) {
+	if storage.sse.Type != sseTypeCustomerKey {
+		return
+	}
+	input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+	input.SSECustomerKey = aws.String(string(storage.sseCustomerKeyRaw))
+	input.SSECustomerKeyMD5 = aws.String(storage.sseCustomerKeyMD5)
+}
+
+// applySSEToHeadInput set SSE-C fields on a HeadObjectInput so that metadata
+// for objects encrypted with a customer supplied key can be retrieved.
+func (storage *S3vStorage) applySSEToHeadInput(input *s3.HeadObjectInput) {
+	if storage.sse.Type != sseTypeCustomerKey {
+		return
+	}
+	input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+	input.SSECustomerKey = aws.String(string(storage.sseCustomerKeyRaw))
+	input.SSECustomerKeyMD5 = aws.String(storage.sseCustomerKeyMD5)
+}
@@ -0,0 +1,38 @@
+package storage
+
+// MatchTags reports whether tags satisfies an include/exclude filter for a
+// tag-based sync stage: every key in include must be present in tags with
+// the same value, and no key in exclude may be present with the same value.
+// A nil or empty include/exclude map is treated as "no constraint".
+func MatchTags(tags, include, exclude map[string]string) bool {
+	for k, v := range include {
+		if tv, ok := tags[k]; !ok || tv != v {
+			return false
+		}
+	}
+	for k, v := range exclude {
+		if tv, ok := tags[k]; ok && tv == v {
+			return false
+		}
+	}
+	return true
+}
+
+// RewriteTags returns a copy of tags with every key present in rewrite
+// replaced by its mapped value, for use by a sync stage that renames or
+// retags objects as they're copied. Keys not present in rewrite are passed
+// through unchanged. Returns nil if tags is empty.
+func RewriteTags(tags, rewrite map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if rv, ok := rewrite[k]; ok {
+			result[k] = rv
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
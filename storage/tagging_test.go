@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchTags(t *testing.T) {
+	tags := map[string]string{"env": "prod", "team": "core"}
+
+	cases := []struct {
+		name    string
+		include map[string]string
+		exclude map[string]string
+		want    bool
+	}{
+		{"no filter", nil, nil, true},
+		{"include match", map[string]string{"env": "prod"}, nil, true},
+		{"include mismatch", map[string]string{"env": "dev"}, nil, false},
+		{"include missing key", map[string]string{"region": "us-east-1"}, nil, false},
+		{"exclude match", nil, map[string]string{"env": "prod"}, false},
+		{"exclude mismatch", nil, map[string]string{"env": "dev"}, true},
+		{"exclude missing key", nil, map[string]string{"region": "us-east-1"}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := MatchTags(tags, c.include, c.exclude); got != c.want {
+				t.Fatalf("MatchTags(%v, %v, %v) = %v, want %v", tags, c.include, c.exclude, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRewriteTags(t *testing.T) {
+	tags := map[string]string{"env": "prod", "team": "core"}
+	rewrite := map[string]string{"env": "staging"}
+
+	got := RewriteTags(tags, rewrite)
+	want := map[string]string{"env": "staging", "team": "core"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("RewriteTags(%v, %v) = %v, want %v", tags, rewrite, got, want)
+	}
+}
+
+func TestRewriteTagsEmpty(t *testing.T) {
+	if got := RewriteTags(nil, map[string]string{"env": "prod"}); got != nil {
+		t.Fatalf("RewriteTags(nil, ...) = %v, want nil", got)
+	}
+}
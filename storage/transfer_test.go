@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func newTestS3vStorage() *S3vStorage {
+	return NewS3vStorage("key", "secret", "us-east-1", "", "bucket", "", 1000, 3, time.Millisecond, SSEConfig{}, TransferConfig{})
+}
+
+func TestNeedsManagedDownload(t *testing.T) {
+	storage := newTestS3vStorage()
+
+	cases := []struct {
+		name string
+		obj  *Object
+		want bool
+	}{
+		{"unknown size", &Object{}, true},
+		{"small object", &Object{ContentLength: aws.Int64(1024)}, false},
+		{"large object", &Object{ContentLength: aws.Int64(storage.transfer.DownloadPartSize + 1)}, true},
+		{"exactly at threshold", &Object{ContentLength: aws.Int64(storage.transfer.DownloadPartSize)}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := storage.needsManagedDownload(c.obj); got != c.want {
+				t.Fatalf("needsManagedDownload(%+v) = %v, want %v", c.obj, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNeedsManagedDownloadArchiveWithRestoreTimeout(t *testing.T) {
+	storage := newTestS3vStorage()
+	storage.WithRestoreTimeout(time.Hour, time.Minute)
+
+	obj := &Object{ContentLength: aws.Int64(1), StorageClass: aws.String("GLACIER")}
+	if !storage.needsManagedDownload(obj) {
+		t.Fatal("expected a small archive-class object to still need a managed download when restore checks are enabled")
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestEncodeDecodeVersionedKeyRoundTrip(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	versionId := aws.String("abc123")
+
+	cases := []string{"foo.txt", "dir/foo.tar.gz", "noext"}
+	for _, key := range cases {
+		versioned := encodeVersionedKey(key, versionId, &mtime)
+		origKey, decoded, hash, ok := DecodeVersionedKey(versioned)
+		if !ok {
+			t.Fatalf("DecodeVersionedKey(%q) returned ok=false", versioned)
+		}
+		if origKey != key {
+			t.Fatalf("DecodeVersionedKey(%q) key = %q, want %q", versioned, origKey, key)
+		}
+		if !decoded.Equal(mtime) {
+			t.Fatalf("DecodeVersionedKey(%q) mtime = %s, want %s", versioned, decoded, mtime)
+		}
+		if hash != versionIdHash(versionId) {
+			t.Fatalf("DecodeVersionedKey(%q) hash = %q, want %q", versioned, hash, versionIdHash(versionId))
+		}
+	}
+}
+
+func TestDecodeVersionedKeyRejectsPlainKey(t *testing.T) {
+	if _, _, _, ok := DecodeVersionedKey("foo.txt"); ok {
+		t.Fatal("expected ok=false for a key without a version suffix")
+	}
+}
+
+func TestEncodeVersionedKeyDistinguishesSameSecondVersions(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	a := encodeVersionedKey("foo.txt", aws.String("version-a"), &mtime)
+	b := encodeVersionedKey("foo.txt", aws.String("version-b"), &mtime)
+	if a == b {
+		t.Fatalf("expected distinct versioned keys for distinct version IDs sharing a LastModified second, got %q for both", a)
+	}
+}